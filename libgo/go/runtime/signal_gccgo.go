@@ -57,6 +57,106 @@ func (c *sigctxt) sigcode() uint64 {
 	return uint64(c.info.si_code)
 }
 
+// isarchive and islibrary record whether this program was built with
+// -buildmode=c-archive or -buildmode=c-shared. They are set by the
+// libgo C startup shim before any Go code runs. When either is true,
+// libgo is being linked into a larger program that may have installed
+// its own signal handlers before libgo's init ran, and setsig must
+// preserve and eventually restore control to those handlers.
+var (
+	isarchive bool
+	islibrary bool
+)
+
+// fwdSig holds the signal handlers that were installed before setsig
+// replaced them, recorded from the oact argument of sigaction. It is
+// only consulted when isarchive or islibrary is set, to forward
+// signals libgo does not own back to whatever non-Go code installed
+// them first.
+var fwdSig [_NSIG]uintptr
+
+// fwdSigActFlags holds the sa_flags that accompanied each fwdSig
+// entry, also recorded from the oact argument of sigaction. sigfwd
+// uses the SA_SIGINFO bit here to tell whether the saved handler
+// expects the three-argument (sig, info, ctx) convention or the
+// traditional one-argument (sig) convention, rather than assuming
+// every forwarded handler is SA_SIGINFO.
+var fwdSigActFlags [_NSIG]uint32
+
+// SignalAction describes how the runtime should dispose of a signal,
+// overriding the default policy derived from sigtable[i].flags.
+type SignalAction int32
+
+const (
+	// HandleInGo runs the signal through the normal Go runtime
+	// handler, as sigtable[i].flags would otherwise dictate. This is
+	// the default for every signal.
+	HandleInGo SignalAction = iota
+	// ForwardToPrevious hands the signal to the handler that was
+	// installed before libgo's init ran, without running Go's handler.
+	// This applies only in c-archive and c-shared builds, and only when
+	// that earlier handler is neither SIG_DFL nor SIG_IGN; otherwise the
+	// signal is handled in Go, as with HandleInGo.
+	ForwardToPrevious
+	// IgnoreIfFromC drops the signal when the fault occurred outside
+	// of Go code, but still handles it normally when it didn't.
+	IgnoreIfFromC
+	// ResetToDefaultAndReraise installs the signal's default
+	// disposition and re-raises it, e.g. so a core dump reflects the
+	// process's actual state.
+	ResetToDefaultAndReraise
+)
+
+// sigActions holds the per-signal policy set by SetSignalHandler. It
+// starts out zero-valued, i.e. HandleInGo for every signal, which
+// reproduces today's sigtable[i].flags-only behavior.
+var sigActions [_NSIG]SignalAction
+
+// SetSignalHandler overrides how the runtime disposes of sig,
+// regardless of what sigtable[i].flags would otherwise say. This is
+// the piece that lets libgo be embedded into a larger process, such
+// as a JVM, a Python interpreter, or another signal-sensitive daemon,
+// without fighting that host over who owns a given signal: an
+// embedder can drop a signal that arrives outside Go code
+// (IgnoreIfFromC), pass a signal to the host's own earlier handler
+// (ForwardToPrevious), or have a signal reset to its default
+// disposition and raised again (ResetToDefaultAndReraise). With
+// ResetToDefaultAndReraise, Go's handler does not run for the signal.
+func SetSignalHandler(sig int32, action SignalAction) {
+	if sig < 0 || sig >= _NSIG {
+		return
+	}
+	sigActions[sig] = action
+}
+
+// sigInstallGoHandler reports whether the signal handler for signal i
+// should be the Go runtime's handler rather than, say, the handler
+// that a host program installed before loading libgo.
+func sigInstallGoHandler(i int32) bool {
+	switch sigActions[i] {
+	case ForwardToPrevious, IgnoreIfFromC:
+		return false
+	case ResetToDefaultAndReraise:
+		return true
+	}
+
+	t := &sigtable[i]
+	if t.flags&(_SigNotify|_SigKill) == 0 {
+		return false
+	}
+	// When built into a larger C program, we only take over a
+	// signal that the runtime actually needs in order to function
+	// (panics, and signals the program asked to catch). Everything
+	// else is left to whatever the host program had already
+	// installed, so that libgo behaves like a well-mannered guest.
+	if isarchive || islibrary {
+		if t.flags&_SigPanic == 0 && fwdSig[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 //go:nosplit
 func msigsave(mp *m) {
 	sigprocmask(_SIG_SETMASK, nil, &mp.sigmask)
@@ -74,6 +174,12 @@ func sigblock() {
 	sigprocmask(_SIG_SETMASK, &set, nil)
 }
 
+// setsig installs fn as the handler for signal i. It is called
+// synchronously during libgo's init, before the runtime starts any
+// background threads, so that a host program linking libgo as a
+// c-archive or c-shared library sees a deterministic, race-free
+// ordering against its own sigaction calls.
+//
 //go:nosplit
 //go:nowritebarrierrec
 func setsig(i int32, fn uintptr, restart bool) {
@@ -94,7 +200,15 @@ func setsig(i int32, fn uintptr, restart bool) {
 	}
 	sigfillset((*sigset)(unsafe.Pointer(&sa.sa_mask)))
 	setSigactionHandler(&sa, fn)
-	sigaction(i, &sa, nil)
+
+	// Save whatever handler, if any, was already installed for this
+	// signal so that sigfwdgo can chain to it later. This matters
+	// for c-archive/c-shared builds, where non-Go code may have
+	// installed a SIGSEGV or SIGPIPE handler before libgo's init ran.
+	var oldsa _sigaction
+	sigaction(i, &sa, &oldsa)
+	fwdSig[i] = getSigactionHandler(&oldsa)
+	fwdSigActFlags[i] = uint32(oldsa.sa_flags)
 }
 
 //go:nosplit
@@ -130,17 +244,18 @@ func getsig(i int32) uintptr {
 
 func signalstack(p unsafe.Pointer, n uintptr)
 
+// updatesigmask sets the current thread's signal mask to m. m is now
+// the OS-native sigset type rather than the old fixed [4]uint32
+// abstraction, so this is a single struct copy rather than an
+// O(_NSIG) loop of sigaddset calls; m.sigmask (see msigsave and
+// msigrestore above) was already stored this way, and the old
+// sigmask shim type has been removed now that every caller deals in
+// sigset directly.
+//
 //go:nosplit
 //go:nowritebarrierrec
-func updatesigmask(m sigmask) {
-	var mask sigset
-	sigemptyset(&mask)
-	for i := int32(0); i < _NSIG; i++ {
-		if m[(i-1)/32]&(1<<((uint(i)-1)&31)) != 0 {
-			sigaddset(&mask, i)
-		}
-	}
-	sigprocmask(_SIG_SETMASK, &mask, nil)
+func updatesigmask(m sigset) {
+	sigprocmask(_SIG_SETMASK, &m, nil)
 }
 
 func unblocksig(sig int32) {
@@ -156,10 +271,76 @@ func raiseproc(sig int32) {
 	kill(getpid(), sig)
 }
 
+// sigfwd invokes fn, the handler saved in fwdSig, using either the
+// three-argument SA_SIGINFO convention or the traditional
+// one-argument convention depending on actFlags, the sa_flags the
+// handler was originally installed with (see fwdSigActFlags). Calling
+// a non-SA_SIGINFO handler with the three-argument signature would
+// read info and ctx as if they were additional integer arguments,
+// which on most ABIs are simply ignored, but nothing guarantees that.
+//
 //go:nosplit
 //go:nowritebarrierrec
-func sigfwd(fn uintptr, sig uint32, info *_siginfo_t, ctx unsafe.Pointer) {
+func sigfwd(fn uintptr, sig uint32, info *_siginfo_t, ctx unsafe.Pointer, actFlags uint32) {
+	if actFlags&_SA_SIGINFO == 0 {
+		f1 := &[1]uintptr{fn}
+		f2 := *(*func(uint32))(unsafe.Pointer(&f1))
+		f2(sig)
+		return
+	}
 	f1 := &[1]uintptr{fn}
 	f2 := *(*func(uint32, *_siginfo_t, unsafe.Pointer))(unsafe.Pointer(&f1))
 	f2(sig, info, ctx)
 }
+
+// sigfwdgo checks whether a given signal should be forwarded to
+// whatever handler, if any, was installed for it before libgo's init
+// ran. It reports whether the signal was passed along, in which case
+// the caller must not treat it as a Go signal.
+//
+//go:nosplit
+//go:nowritebarrierrec
+func sigfwdgo(sig uint32, info *_siginfo_t, ctx unsafe.Pointer) bool {
+	if sig >= uint32(len(sigtable)) {
+		return false
+	}
+
+	fromGo := findfunc((&sigctxt{info, ctx}).pc()).valid()
+
+	switch sigActions[sig] {
+	case IgnoreIfFromC:
+		if !fromGo {
+			return true
+		}
+		return false
+	case ResetToDefaultAndReraise:
+		var sa _sigaction
+		sa.sa_flags = 0
+		setSigactionHandler(&sa, _SIG_DFL)
+		sigaction(int32(sig), &sa, nil)
+		raiseproc(int32(sig))
+		return true
+	}
+
+	if !isarchive && !islibrary {
+		// We installed every handler ourselves; there is nothing
+		// to forward to.
+		return false
+	}
+	if sigInstallGoHandler(int32(sig)) {
+		// We want to handle this signal in Go, but only if the
+		// fault actually happened in Go code: a SIGSEGV raised by
+		// a non-Go thread sharing this process is not something
+		// our panic machinery can make sense of.
+		t := sigtable[sig]
+		if t.flags&_SigPanic == 0 || fromGo {
+			return false
+		}
+	}
+	fwdFn := fwdSig[sig]
+	if fwdFn == _SIG_DFL || fwdFn == _SIG_IGN || fwdFn == 0 {
+		return false
+	}
+	sigfwd(fwdFn, sig, info, ctx, fwdSigActFlags[sig])
+	return true
+}
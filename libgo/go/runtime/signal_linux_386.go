@@ -0,0 +1,42 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,386
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) eax() uint32 { return uint32(c.regs().eax) }
+func (c *sigctxt) ebx() uint32 { return uint32(c.regs().ebx) }
+func (c *sigctxt) ecx() uint32 { return uint32(c.regs().ecx) }
+func (c *sigctxt) edx() uint32 { return uint32(c.regs().edx) }
+func (c *sigctxt) edi() uint32 { return uint32(c.regs().edi) }
+func (c *sigctxt) esi() uint32 { return uint32(c.regs().esi) }
+func (c *sigctxt) ebp() uint32 { return uint32(c.regs().ebp) }
+func (c *sigctxt) esp() uint32 { return uint32(c.regs().esp) }
+
+func (c *sigctxt) pc() uintptr { return uintptr(c.regs().eip) }
+func (c *sigctxt) sp() uintptr { return uintptr(c.regs().esp) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	print("eax    ", hex(c.eax()), "\n")
+	print("ebx    ", hex(c.ebx()), "\n")
+	print("ecx    ", hex(c.ecx()), "\n")
+	print("edx    ", hex(c.edx()), "\n")
+	print("edi    ", hex(c.edi()), "\n")
+	print("esi    ", hex(c.esi()), "\n")
+	print("ebp    ", hex(c.ebp()), "\n")
+	print("esp    ", hex(c.esp()), "\n")
+	print("eip    ", hex(c.pc()), "\n")
+}
@@ -0,0 +1,58 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,amd64
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) rax() uint64 { return uint64(c.regs().rax) }
+func (c *sigctxt) rbx() uint64 { return uint64(c.regs().rbx) }
+func (c *sigctxt) rcx() uint64 { return uint64(c.regs().rcx) }
+func (c *sigctxt) rdx() uint64 { return uint64(c.regs().rdx) }
+func (c *sigctxt) rdi() uint64 { return uint64(c.regs().rdi) }
+func (c *sigctxt) rsi() uint64 { return uint64(c.regs().rsi) }
+func (c *sigctxt) rbp() uint64 { return uint64(c.regs().rbp) }
+func (c *sigctxt) rsp() uint64 { return uint64(c.regs().rsp) }
+func (c *sigctxt) r8() uint64  { return uint64(c.regs().r8) }
+func (c *sigctxt) r9() uint64  { return uint64(c.regs().r9) }
+func (c *sigctxt) r10() uint64 { return uint64(c.regs().r10) }
+func (c *sigctxt) r11() uint64 { return uint64(c.regs().r11) }
+func (c *sigctxt) r12() uint64 { return uint64(c.regs().r12) }
+func (c *sigctxt) r13() uint64 { return uint64(c.regs().r13) }
+func (c *sigctxt) r14() uint64 { return uint64(c.regs().r14) }
+func (c *sigctxt) r15() uint64 { return uint64(c.regs().r15) }
+
+func (c *sigctxt) pc() uintptr { return uintptr(c.regs().rip) }
+func (c *sigctxt) sp() uintptr { return uintptr(c.regs().rsp) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	print("rax    ", hex(c.rax()), "\n")
+	print("rbx    ", hex(c.rbx()), "\n")
+	print("rcx    ", hex(c.rcx()), "\n")
+	print("rdx    ", hex(c.rdx()), "\n")
+	print("rdi    ", hex(c.rdi()), "\n")
+	print("rsi    ", hex(c.rsi()), "\n")
+	print("rbp    ", hex(c.rbp()), "\n")
+	print("rsp    ", hex(c.rsp()), "\n")
+	print("r8     ", hex(c.r8()), "\n")
+	print("r9     ", hex(c.r9()), "\n")
+	print("r10    ", hex(c.r10()), "\n")
+	print("r11    ", hex(c.r11()), "\n")
+	print("r12    ", hex(c.r12()), "\n")
+	print("r13    ", hex(c.r13()), "\n")
+	print("r14    ", hex(c.r14()), "\n")
+	print("r15    ", hex(c.r15()), "\n")
+	print("rip    ", hex(c.pc()), "\n")
+}
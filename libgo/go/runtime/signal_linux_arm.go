@@ -0,0 +1,65 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,arm
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) r(n int) uint32 {
+	switch n {
+	case 0:
+		return uint32(c.regs().arm_r0)
+	case 1:
+		return uint32(c.regs().arm_r1)
+	case 2:
+		return uint32(c.regs().arm_r2)
+	case 3:
+		return uint32(c.regs().arm_r3)
+	case 4:
+		return uint32(c.regs().arm_r4)
+	case 5:
+		return uint32(c.regs().arm_r5)
+	case 6:
+		return uint32(c.regs().arm_r6)
+	case 7:
+		return uint32(c.regs().arm_r7)
+	case 8:
+		return uint32(c.regs().arm_r8)
+	case 9:
+		return uint32(c.regs().arm_r9)
+	case 10:
+		return uint32(c.regs().arm_r10)
+	default:
+		throw("invalid register index")
+		return 0
+	}
+}
+
+func (c *sigctxt) fp() uint32  { return uint32(c.regs().arm_fp) }
+func (c *sigctxt) ip() uint32  { return uint32(c.regs().arm_ip) }
+func (c *sigctxt) sp() uintptr { return uintptr(c.regs().arm_sp) }
+func (c *sigctxt) lr() uint32  { return uint32(c.regs().arm_lr) }
+func (c *sigctxt) pc() uintptr { return uintptr(c.regs().arm_pc) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	for i := 0; i <= 10; i++ {
+		print("r", i, "     ", hex(c.r(i)), "\n")
+	}
+	print("fp     ", hex(c.fp()), "\n")
+	print("ip     ", hex(c.ip()), "\n")
+	print("sp     ", hex(c.sp()), "\n")
+	print("lr     ", hex(c.lr()), "\n")
+	print("pc     ", hex(c.pc()), "\n")
+}
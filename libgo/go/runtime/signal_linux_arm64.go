@@ -0,0 +1,32 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,arm64
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) r(n int) uint64 { return uint64(c.regs().regs[n]) }
+func (c *sigctxt) sp() uintptr    { return uintptr(c.regs().sp) }
+func (c *sigctxt) pc() uintptr    { return uintptr(c.regs().pc) }
+func (c *sigctxt) lr() uintptr    { return uintptr(c.regs().regs[30]) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	for i := 0; i < 29; i++ {
+		print("x", i, "     ", hex(c.r(i)), "\n")
+	}
+	print("lr     ", hex(c.lr()), "\n")
+	print("sp     ", hex(c.sp()), "\n")
+	print("pc     ", hex(c.pc()), "\n")
+}
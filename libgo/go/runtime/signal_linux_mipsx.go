@@ -0,0 +1,32 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+// +build mips mipsle
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) r(n int) uint32 { return uint32(c.regs().sc_regs[n]) }
+
+// Register 29 is $sp in the MIPS o32 ABI.
+func (c *sigctxt) sp() uintptr { return uintptr(c.r(29)) }
+func (c *sigctxt) pc() uintptr { return uintptr(c.regs().sc_pc) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	for i := 0; i < 32; i++ {
+		print("r", i, "     ", hex(c.r(i)), "\n")
+	}
+	print("pc     ", hex(c.pc()), "\n")
+}
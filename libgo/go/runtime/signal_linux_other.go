@@ -0,0 +1,22 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+// +build !386,!amd64,!arm,!arm64,!mips,!mipsle,!ppc64,!ppc64le,!s390x
+
+package runtime
+
+// This file backs sigctxt.pc/sp for any linux/GOARCH not covered by
+// one of the dedicated signal_linux_*.go files (e.g. mips64,
+// mips64le, riscv64), the same conservative stand-in
+// signal_sigctxt_fallback.go provides for the non-linux GOOSes
+// signal_gccgo.go also builds for. See that file's comment for why
+// zero, rather than a guessed struct layout, is the right fallback
+// here.
+func (c *sigctxt) pc() uintptr { return 0 }
+func (c *sigctxt) sp() uintptr { return 0 }
+
+func dumpregs(c *sigctxt) {
+	print("(register dump unavailable on this GOARCH)\n")
+}
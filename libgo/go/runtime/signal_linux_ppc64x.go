@@ -0,0 +1,38 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+// +build ppc64 ppc64le
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) r(n int) uint64 { return uint64(c.regs().gp_regs[n]) }
+
+// PT_NIP and PT_R1 index gp_regs the same way glibc's <asm/ptrace.h>
+// names them: the saved next-instruction-pointer and stack pointer.
+const (
+	_PT_R1  = 1
+	_PT_NIP = 32
+)
+
+func (c *sigctxt) sp() uintptr { return uintptr(c.r(_PT_R1)) }
+func (c *sigctxt) pc() uintptr { return uintptr(c.r(_PT_NIP)) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	for i := 0; i < 32; i++ {
+		print("r", i, "     ", hex(c.r(i)), "\n")
+	}
+	print("pc     ", hex(c.pc()), "\n")
+}
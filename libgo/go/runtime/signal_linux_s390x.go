@@ -0,0 +1,30 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux,s390x
+
+package runtime
+
+import "unsafe"
+
+// regs returns the saved mcontext out of the ucontext_t passed to a
+// three-arg SA_SIGINFO handler.
+func (c *sigctxt) regs() *_sigcontext {
+	return (*_sigcontext)(unsafe.Pointer(&(*_ucontext)(c.ctxt).uc_mcontext))
+}
+
+func (c *sigctxt) r(n int) uint64 { return uint64(c.regs().sregs.regs.gprs[n]) }
+
+func (c *sigctxt) sp() uintptr { return uintptr(c.r(15)) }
+func (c *sigctxt) pc() uintptr { return uintptr(c.regs().sregs.regs.psw.addr) }
+
+// dumpregs prints the saved register state, in the style of gc's
+// runtime, for the fatal-signal path to call before the program
+// aborts.
+func dumpregs(c *sigctxt) {
+	for i := 0; i < 16; i++ {
+		print("r", i, "     ", hex(c.r(i)), "\n")
+	}
+	print("pc     ", hex(c.pc()), "\n")
+}
@@ -0,0 +1,23 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build darwin dragonfly freebsd netbsd openbsd solaris
+
+package runtime
+
+// This file backs sigctxt.pc/sp on every GOOS signal_gccgo.go builds
+// for besides linux. Each of those kernels lays out ucontext_t's
+// saved mcontext differently per GOARCH, and this tree doesn't carry
+// the per-GOOS/GOARCH cgo-generated _sigcontext definitions a real
+// accessor would need, unlike the linux/amd64 and linux/arm64 cases
+// in signal_linux_amd64.go and signal_linux_arm64.go. Returning 0
+// keeps the build green rather than guessing at a struct layout: it
+// makes findfunc(0).valid() report false, so sigfwdgo conservatively
+// treats every fault on these GOOSes as not coming from Go code.
+func (c *sigctxt) pc() uintptr { return 0 }
+func (c *sigctxt) sp() uintptr { return 0 }
+
+func dumpregs(c *sigctxt) {
+	print("(register dump unavailable on this GOOS)\n")
+}
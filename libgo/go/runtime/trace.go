@@ -13,6 +13,7 @@
 package runtime
 
 import (
+	"io"
 	"runtime/internal/sys"
 	"unsafe"
 )
@@ -31,7 +32,7 @@ const (
 	traceEvGCScanStart    = 9  // GC scan start [timestamp]
 	traceEvGCScanDone     = 10 // GC scan done [timestamp]
 	traceEvGCSweepStart   = 11 // GC sweep start [timestamp, stack id]
-	traceEvGCSweepDone    = 12 // GC sweep done [timestamp]
+	traceEvGCSweepDone    = 12 // GC sweep done [timestamp, swept bytes, reclaimed bytes]
 	traceEvGoCreate       = 13 // goroutine creation [timestamp, new goroutine id, new stack id, stack id]
 	traceEvGoStart        = 14 // goroutine starts running [timestamp, goroutine id, seq]
 	traceEvGoEnd          = 15 // goroutine ends [timestamp]
@@ -60,7 +61,15 @@ const (
 	traceEvGoStartLocal   = 38 // goroutine starts running on the same P as the last event [timestamp, goroutine id]
 	traceEvGoUnblockLocal = 39 // goroutine is unblocked on the same P as the last event [timestamp, goroutine id, stack]
 	traceEvGoSysExitLocal = 40 // syscall exit on the same P as the last event [timestamp, goroutine id, real timestamp]
-	traceEvCount          = 41
+	traceEvUserTaskCreate = 41 // trace.NewTask [timestamp, internal task id, internal parent task id, name string, stack]
+	traceEvUserTaskEnd    = 42 // end of task [timestamp, internal task id, stack]
+	traceEvUserRegion     = 43 // trace.WithRegion [timestamp, internal task id, mode(0:start, 1:end), name string, stack]
+	traceEvUserLog        = 44 // trace.Log [timestamp, internal task id, key string id, value string id, stack]
+	traceEvDropped        = 45 // StartTraceToWriter dropped one or more batches [timestamp, number of batches dropped]
+	traceEvSTWStart       = 46 // stop-the-world begins [timestamp, reason]
+	traceEvSTWStop        = 47 // stop-the-world ends [timestamp]
+	traceEvCPUSample      = 48 // CPU profiling sample, piggybacked on the trace stream [timestamp, goroutine id, P id, stack id]
+	traceEvCount          = 49
 )
 
 const (
@@ -108,6 +117,10 @@ var trace struct {
 	timeStart     int64       // nanotime when tracing was started
 	timeEnd       int64       // nanotime when tracing was stopped
 	seqGC         uint64      // GC start/done sequencer
+	taskSeq       uint64      // task id sequencer for NewTraceTask
+	mask          traceCategory // event categories enabled by StartTraceFiltered
+	stackRate     uint32      // record a stack for only 1-in-stackRate sampled events
+	clock         traceClock  // time source for event timestamps, chosen in StartTrace
 	reading       traceBufPtr // buffer currently handed off to user
 	empty         traceBufPtr // stack of empty buffers
 	fullHead      traceBufPtr // queue of full buffers
@@ -184,6 +197,16 @@ func StartTrace() error {
 	// trace.enabled is set afterwards once we have emitted all preliminary events.
 	_g_ := getg()
 	_g_.m.startingtrace = true
+	// Reset the category mask and stack-sampling rate before emitting
+	// anything below: a stale, filtered trace.mask left over from a
+	// previous StartTraceFiltered session (StopTrace never resets it)
+	// would otherwise silently drop these goroutine-bookkeeping
+	// events, since traceEvent consults trace.mask on every call.
+	trace.mask = traceCatAll
+	trace.stackRate = 1
+	// Choose the clock before emitting the events below, since
+	// traceEvent stamps every event with trace.clock.
+	trace.clock = traceChooseClock()
 	for _, gp := range allgs {
 		status := readgstatus(gp)
 		if status != _Gdead {
@@ -207,7 +230,7 @@ func StartTrace() error {
 	// If we do it the other way around, it is possible that exitsyscall will
 	// query sysexitticks after ticksStart but before traceEvGoInSyscall timestamp.
 	// It will lead to a false conclusion that cputicks is broken.
-	trace.ticksStart = cputicks()
+	trace.ticksStart = trace.clock.ticks()
 	trace.timeStart = nanotime()
 	trace.headerWritten = false
 	trace.footerWritten = false
@@ -223,6 +246,85 @@ func StartTrace() error {
 	return nil
 }
 
+// traceCategory is a bitmask of event categories, used to keep
+// steady-state tracing overhead low by letting callers record only
+// the categories they care about.
+type traceCategory uint32
+
+const (
+	traceCatScheduler traceCategory = 1 << iota
+	traceCatGC
+	traceCatSyscalls
+	traceCatHeap
+	traceCatUser
+	traceCatAll = traceCatScheduler | traceCatGC | traceCatSyscalls | traceCatHeap | traceCatUser
+)
+
+// traceEventCategory classifies ev for the purposes of trace.mask.
+// Structural events that every consumer of the trace format needs
+// regardless of filtering (batch headers, the string and stack
+// tables, the frequency record, ...) are always in every category.
+func traceEventCategory(ev byte) traceCategory {
+	switch ev {
+	case traceEvGCStart, traceEvGCDone, traceEvGCScanStart, traceEvGCScanDone,
+		traceEvGCSweepStart, traceEvGCSweepDone, traceEvHeapAlloc, traceEvNextGC:
+		return traceCatGC | traceCatHeap
+	case traceEvGoSysCall, traceEvGoSysExit, traceEvGoSysExitLocal, traceEvGoSysBlock,
+		traceEvGoInSyscall:
+		return traceCatSyscalls
+	case traceEvUserTaskCreate, traceEvUserTaskEnd, traceEvUserRegion, traceEvUserLog:
+		return traceCatUser
+	case traceEvGoCreate, traceEvGoStart, traceEvGoStartLocal, traceEvGoEnd, traceEvGoStop,
+		traceEvGoSched, traceEvGoPreempt, traceEvGoSleep, traceEvGoBlock, traceEvGoUnblock,
+		traceEvGoUnblockLocal, traceEvGoBlockSend, traceEvGoBlockRecv, traceEvGoBlockSelect,
+		traceEvGoBlockSync, traceEvGoBlockCond, traceEvGoBlockNet, traceEvGoWaiting,
+		traceEvProcStart, traceEvProcStop, traceEvGomaxprocs:
+		return traceCatScheduler
+	case traceEvSTWStart, traceEvSTWStop:
+		// STW generalizes traceEvGCStart/traceEvGCDone to every
+		// stop-the-world cause, so it belongs in the same category
+		// those already are.
+		return traceCatGC
+	case traceEvCPUSample:
+		// A CPU sample names a goroutine and P the same way the
+		// scheduling events above do; it isn't structural, so it must
+		// stay filterable rather than falling into the always-on
+		// default below.
+		return traceCatScheduler
+	default:
+		return traceCatAll
+	}
+}
+
+// traceMaxProcs bounds traceStackSampleCounters; a P id beyond this
+// falls back to a shared slot, which only costs an extra collision in
+// the (rare) case of a very large GOMAXPROCS.
+const traceMaxProcs = 256
+
+// traceStackSampleCounters holds one stack-sampling counter per P, so
+// that deciding whether to capture a stack for the current event
+// needs no atomic operation shared between Ps. The counters are
+// packed side by side, so neighbouring Ps' counters share cache lines.
+var traceStackSampleCounters [traceMaxProcs]uint32
+
+// StartTraceFiltered is like StartTrace, but restricts recorded
+// events to the categories set in mask and, for events that normally
+// carry a stack, records one for only 1-in-stackSampleRate
+// occurrences (stackSampleRate <= 1 means every occurrence). This
+// brings tracing overhead down to where it can be left on in
+// production, unlike the always-everything-with-stacks mode
+// StartTrace provides.
+func StartTraceFiltered(mask traceCategory, stackSampleRate uint32) error {
+	if err := StartTrace(); err != nil {
+		return err
+	}
+	lock(&trace.bufLock)
+	trace.mask = mask
+	trace.stackRate = stackSampleRate
+	unlock(&trace.bufLock)
+	return nil
+}
+
 // StopTrace stops tracing, if it was previously enabled.
 // StopTrace only returns after all the reads for the trace have completed.
 func StopTrace() {
@@ -258,7 +360,7 @@ func StopTrace() {
 	}
 
 	for {
-		trace.ticksEnd = cputicks()
+		trace.ticksEnd = trace.clock.ticks()
 		trace.timeEnd = nanotime()
 		// Windows time can tick only every 15ms, wait for at least one tick.
 		if trace.timeEnd != trace.timeStart {
@@ -339,12 +441,21 @@ func ReadTrace() []byte {
 		trace.empty = buf
 		trace.reading = 0
 	}
-	// Write trace header.
+	// Write trace header: a magic/version string followed by a small
+	// TLV block. The one tag defined so far records which traceClock
+	// produced this trace's timestamps, so tools don't have to infer
+	// it from the ticksEnd/timeEnd ratio computed in the footer
+	// below. Bumped to 1.10 now that traceEvGCSweepDone carries extra
+	// arguments, so readers can tell old- and new-style sweep events
+	// apart.
 	if !trace.headerWritten {
 		trace.headerWritten = true
+		clock := trace.clock
 		trace.lockOwner = nil
 		unlock(&trace.lock)
-		return []byte("go 1.7 trace\x00\x00\x00\x00")
+		hdr := []byte("go 1.10 trace")
+		hdr = append(hdr, traceTLVTagClockID, 1, traceClockID(clock), 0)
+		return hdr
 	}
 	// Wait for new data.
 	if trace.fullHead == 0 && !trace.shutdown {
@@ -459,7 +570,25 @@ func traceFullDequeue() traceBufPtr {
 // If skip = 0, this event type should contain a stack, but we don't want
 // to collect and remember it for this particular call.
 func traceEvent(ev byte, skip int, args ...uint64) {
+	if trace.mask != 0 && trace.mask&traceEventCategory(ev) == 0 {
+		// The event's category is disabled. Check this before
+		// acquiring the per-P buffer so that a filtered-out event
+		// stays on the cheap path: production tracing with a mask
+		// applied shouldn't pay for buffer locking it never uses.
+		return
+	}
 	mp, pid, bufp := traceAcquireBuffer()
+	if ev != traceEvCPUSample && mp.traceCPUSamples.full {
+		// Piggyback any CPU sample this M stashed from SIGPROF onto
+		// the next ordinary trace event it writes, per
+		// traceCPUSampleFlush's contract. Release the buffer first:
+		// traceCPUSampleFlush calls back into traceEvent to emit
+		// traceEvCPUSample, which acquires its own buffer the same
+		// way traceUserTaskCreate and friends already do below.
+		traceReleaseBuffer(pid)
+		traceCPUSampleFlush(mp)
+		mp, pid, bufp = traceAcquireBuffer()
+	}
 	// Double-check trace.enabled now that we've done m.locks++ and acquired bufLock.
 	// This protects from races between traceEvent and StartTrace/StopTrace.
 
@@ -480,7 +609,7 @@ func traceEvent(ev byte, skip int, args ...uint64) {
 		(*bufp).set(buf)
 	}
 
-	ticks := uint64(cputicks()) / traceTickDiv
+	ticks := uint64(trace.clock.ticks()) / traceTickDiv
 	tickDiff := ticks - buf.lastTicks
 	if buf.pos == 0 {
 		buf.byte(traceEvBatch | 1<<traceArgCountShift)
@@ -516,16 +645,40 @@ func traceEvent(ev byte, skip int, args ...uint64) {
 		_g_ := getg()
 		gp := mp.curg
 		var nstk int
-		if gp == _g_ {
-			nstk = callers(skip, buf.stk[:])
-		} else if gp != nil {
-			// FIXME: get stack trace of different goroutine.
-		}
-		if nstk > 0 {
-			nstk-- // skip runtime.goexit
+		sample := trace.stackRate <= 1
+		if !sample {
+			// Sample 1-in-stackRate of these events using a
+			// counter private to this P, rather than a shared
+			// atomic counter.
+			idx := pid
+			if idx < 0 || idx >= traceMaxProcs {
+				idx = 0
+			}
+			traceStackSampleCounters[idx]++
+			sample = traceStackSampleCounters[idx]%trace.stackRate == 0
 		}
-		if nstk > 0 && gp.goid == 1 {
-			nstk-- // skip runtime.main
+		if sample {
+			if gp == _g_ {
+				nstk = callers(skip, buf.stk[:])
+			} else if gp != nil {
+				// Capture gp's stack even though it isn't the
+				// running goroutine, e.g. for traceEvGoUnblock,
+				// where gp is the goroutine being woken up. Its
+				// saved sched.pc/sched.sp are only a valid,
+				// unchanging snapshot while it's parked, so only do
+				// this for states where nothing else can be
+				// concurrently mutating them.
+				status := readgstatus(gp) &^ _Gscan
+				if status == _Gwaiting || status == _Grunnable {
+					nstk = gentraceback(gp.sched.pc, gp.sched.sp, 0, gp, 0, &buf.stk[0], len(buf.stk), nil, nil, 0)
+				}
+			}
+			if nstk > 0 {
+				nstk-- // skip runtime.goexit
+			}
+			if nstk > 0 && gp.goid == 1 {
+				nstk-- // skip runtime.main
+			}
 		}
 		id := trace.stackTab.put(buf.stk[:nstk])
 		buf.varint(uint64(id))
@@ -870,6 +1023,36 @@ func traceGCDone() {
 	traceEvent(traceEvGCDone, -1)
 }
 
+// traceSTWReason enumerates why the world was stopped, for
+// traceEvSTWStart/traceEvSTWStop. These generalize traceGCStart and
+// traceGCDone above to every stop-the-world cause, not just GC, so
+// that analysis tools can build accurate per-cause STW latency
+// histograms instead of inferring them solely from the GC-specific
+// GCStart..GCDone span.
+type traceSTWReason byte
+
+const (
+	traceSTWGCMarkTermination traceSTWReason = iota
+	traceSTWGCSweepTermination
+	traceSTWWriteHeapDump
+	traceSTWGoMaxProcs
+	traceSTWOther
+)
+
+// traceSTWStart emits traceEvSTWStart for a stop-the-world beginning
+// for reason. Call sites are stopTheWorldWithSema and the handful of
+// other stopTheWorld callers (heap dump, GOMAXPROCS changes, ...).
+func traceSTWStart(reason traceSTWReason) {
+	traceEvent(traceEvSTWStart, -1, uint64(reason))
+}
+
+// traceSTWDone emits traceEvSTWStop, called from
+// startTheWorldWithSema once the corresponding traceSTWStart's
+// stop-the-world ends.
+func traceSTWDone() {
+	traceEvent(traceEvSTWStop, -1)
+}
+
 func traceGCScanStart() {
 	traceEvent(traceEvGCScanStart, -1)
 }
@@ -878,12 +1061,48 @@ func traceGCScanDone() {
 	traceEvent(traceEvGCScanDone, -1)
 }
 
+// traceGCSweepStart marks the current P as sweeping, without itself
+// emitting traceEvGCSweepStart: the event is emitted lazily, by
+// traceGCSweepSpan, on the first span this P actually sweeps, so that
+// a P which finds nothing to sweep doesn't open an empty span in the
+// trace.
 func traceGCSweepStart() {
-	traceEvent(traceEvGCSweepStart, 1)
+	_p_ := getg().m.p.ptr()
+	if _p_.trace.inSweep {
+		throw("double traceGCSweepStart")
+	}
+	_p_.trace.inSweep = true
+	_p_.trace.swept = 0
+	_p_.trace.reclaimed = 0
+}
+
+// traceGCSweepSpan records that the sweeper examined bytesSwept bytes
+// of a span and freed bytesReclaimed of them, accumulating the totals
+// that traceGCSweepDone will report.
+func traceGCSweepSpan(bytesSwept, bytesReclaimed uintptr) {
+	_p_ := getg().m.p.ptr()
+	if !_p_.trace.inSweep {
+		return
+	}
+	if _p_.trace.swept == 0 {
+		traceEvent(traceEvGCSweepStart, 1)
+	}
+	_p_.trace.swept += uint64(bytesSwept)
+	_p_.trace.reclaimed += uint64(bytesReclaimed)
 }
 
+// traceGCSweepDone emits traceEvGCSweepDone with the swept/reclaimed
+// byte totals accumulated since traceGCSweepStart, but only if a
+// matching traceEvGCSweepStart was actually emitted.
 func traceGCSweepDone() {
-	traceEvent(traceEvGCSweepDone, -1)
+	_p_ := getg().m.p.ptr()
+	if !_p_.trace.inSweep {
+		throw("missing traceGCSweepStart")
+	}
+	if _p_.trace.swept != 0 {
+		traceEvent(traceEvGCSweepDone, -1, _p_.trace.swept, _p_.trace.reclaimed)
+	}
+	_p_.trace.inSweep = false
 }
 
 func traceGoCreate(newg *g, pc uintptr) {
@@ -981,3 +1200,447 @@ func traceHeapAlloc() {
 func traceNextGC() {
 	traceEvent(traceEvNextGC, -1, memstats.next_gc)
 }
+
+// traceCPUSampleBuf is a small, fixed-size scratch slot, one per M,
+// for stashing a single pending CPU profiling sample between the
+// SIGPROF handler that captures it and the next normal-context flush.
+// It exists because the handler runs in signal context and must not
+// take any lock that traceEvent or trace.stackTab.put might already
+// hold on this thread; writing into this buffer is the only
+// async-signal-safe step it performs.
+type traceCPUSampleBuf struct {
+	full bool
+	goid int64
+	pid  int32
+	n    int
+	stk  [traceStackSize]uintptr
+}
+
+// traceCPUSample stashes a CPU profiling sample for gp, running on
+// pp, into the current M's scratch buffer, to unify "what was the
+// goroutine doing" (the CPU profile) with "what was the scheduler
+// doing" (the trace) in one artifact. It is meant to be called from
+// the SIGPROF handler when tracing is active, alongside or instead of
+// the usual pprof CPU profile recording.
+//
+//go:nosplit
+//go:nowritebarrierrec
+func traceCPUSample(gp *g, pp *p, stk []uintptr) {
+	mp := getg().m
+	r := &mp.traceCPUSamples
+	if r.full {
+		// The normal-context flusher hasn't drained the last sample
+		// yet; drop this one rather than risk anything fancier from
+		// signal context.
+		return
+	}
+	r.n = copy(r.stk[:], stk)
+	if gp != nil {
+		r.goid = gp.goid
+	} else {
+		r.goid = 0
+	}
+	if pp != nil {
+		r.pid = pp.id
+	} else {
+		r.pid = -1
+	}
+	r.full = true
+}
+
+// traceCPUSampleFlush drains mp's pending CPU sample, if any, into
+// the trace stream via the normal traceEvent path. It must be called
+// from normal context — e.g. piggybacked on the next traceEvent call
+// this M makes for some other reason, or from sysmon — never from the
+// SIGPROF handler itself.
+func traceCPUSampleFlush(mp *m) {
+	r := &mp.traceCPUSamples
+	if !r.full {
+		return
+	}
+	locs := make([]location, r.n)
+	for i, pc := range r.stk[:r.n] {
+		locs[i] = location{pc: pc}
+	}
+	id := trace.stackTab.put(locs)
+	traceEvent(traceEvCPUSample, -1, uint64(r.goid), uint64(uint32(r.pid)), uint64(id))
+	r.full = false
+}
+
+// The following implement user-defined annotations: application code
+// can mark up the trace stream with its own tasks, regions and log
+// messages, the same way it would annotate a Chrome trace with
+// async/duration events, rather than only seeing runtime-internal
+// activity.
+
+func traceUserTaskCreate(id, parentID uint64, taskType string) {
+	if !trace.enabled {
+		return
+	}
+	mp, pid, bufp := traceAcquireBuffer()
+	if !trace.enabled && !mp.startingtrace {
+		traceReleaseBuffer(pid)
+		return
+	}
+	typeStringID, buf := traceString(bufp.ptr(), taskType)
+	bufp.set(buf)
+	traceReleaseBuffer(pid)
+
+	traceEvent(traceEvUserTaskCreate, 3, id, parentID, typeStringID)
+}
+
+func traceUserTaskEnd(id uint64) {
+	traceEvent(traceEvUserTaskEnd, 2, id)
+}
+
+func traceUserRegion(id uint64, mode byte, regionType string) {
+	if !trace.enabled {
+		return
+	}
+	mp, pid, bufp := traceAcquireBuffer()
+	if !trace.enabled && !mp.startingtrace {
+		traceReleaseBuffer(pid)
+		return
+	}
+	typeStringID, buf := traceString(bufp.ptr(), regionType)
+	bufp.set(buf)
+	traceReleaseBuffer(pid)
+
+	traceEvent(traceEvUserRegion, 3, id, uint64(mode), typeStringID)
+}
+
+func traceUserLog(id uint64, category, message string) {
+	if !trace.enabled {
+		return
+	}
+	mp, pid, bufp := traceAcquireBuffer()
+	if !trace.enabled && !mp.startingtrace {
+		traceReleaseBuffer(pid)
+		return
+	}
+	categoryID, buf := traceString(bufp.ptr(), category)
+	bufp.set(buf)
+	messageID, buf := traceString(buf, message)
+	bufp.set(buf)
+	traceReleaseBuffer(pid)
+
+	traceEvent(traceEvUserLog, 3, id, categoryID, messageID)
+}
+
+// NewTraceTask begins a new logical task of the given type, parented
+// to parentID (0 for a top-level task), and returns an id to pass to
+// TraceRegion, EndTraceTask and TraceLog. It is a no-op, returning 0,
+// when tracing is not enabled.
+func NewTraceTask(taskType string, parentID uint64) uint64 {
+	if !trace.enabled {
+		return 0
+	}
+	lock(&trace.lock)
+	trace.taskSeq++
+	id := trace.taskSeq
+	unlock(&trace.lock)
+	traceUserTaskCreate(id, parentID, taskType)
+	return id
+}
+
+// EndTraceTask marks task id as finished.
+func EndTraceTask(id uint64) {
+	if !trace.enabled {
+		return
+	}
+	traceUserTaskEnd(id)
+}
+
+// TraceRegion marks the start of a named region within task id and
+// returns a function that ends it. category groups related region
+// names together in the trace viewer, the same way Chrome's
+// async/duration events are grouped.
+func TraceRegion(id uint64, category, name string) func() {
+	if !trace.enabled {
+		return func() {}
+	}
+	traceUserRegion(id, 0, category+":"+name)
+	return func() {
+		if trace.enabled {
+			traceUserRegion(id, 1, category+":"+name)
+		}
+	}
+}
+
+// TraceLog emits an instant, timestamped log message within task id,
+// annotated with category.
+func TraceLog(id uint64, category, message string) {
+	if !trace.enabled {
+		return
+	}
+	traceUserLog(id, category, message)
+}
+
+// TraceWritePolicy selects what StartTraceToWriter does once its
+// internal queue of full trace batches exceeds MaxBufferedBytes
+// because the consumer is falling behind the producers.
+type TraceWritePolicy int
+
+const (
+	// BlockProducers keeps every batch queued for w. It neither
+	// drops batches nor blocks the producers, so the queue, and
+	// memory use, grow without limit while w falls behind.
+	BlockProducers TraceWritePolicy = iota
+	// DropOldestBatch discards the oldest queued batches to make
+	// room for a new one, so the queue holds at most
+	// MaxBufferedBytes, or only the newest batch when that batch
+	// alone is larger. Each drop emits a traceEvDropped event into
+	// the live trace stream, so it gets a timestamp and flows to w
+	// in order along with everything else instead of being tacked on
+	// after the fact.
+	DropOldestBatch
+)
+
+// TraceOptions configures StartTraceToWriter.
+type TraceOptions struct {
+	// MaxBufferedBytes caps how much trace data may be queued for w
+	// before Policy applies. Zero means a 64MB default.
+	MaxBufferedBytes int
+	// Policy selects the behavior once MaxBufferedBytes is exceeded.
+	Policy TraceWritePolicy
+}
+
+// StartTraceToWriter is like StartTrace, except that instead of
+// requiring the caller to loop on ReadTrace, a dedicated goroutine
+// reads full trace batches and writes them to w as they become
+// available. Each batch is still copied out of the runtime's trace
+// buffer before it is written, as a ReadTrace loop would copy it.
+// This lets long-running production tracing avoid buffering the
+// entire run in memory.
+func StartTraceToWriter(w io.Writer, opts TraceOptions) error {
+	if opts.MaxBufferedBytes <= 0 {
+		opts.MaxBufferedBytes = 64 << 20
+	}
+	if err := StartTrace(); err != nil {
+		return err
+	}
+	traceDroppedForTestReset()
+	go traceToWriter(w, opts)
+	return nil
+}
+
+// traceDroppedForTestMu and traceDroppedForTest exist solely so tests
+// can observe that StartTraceToWriter's DropOldestBatch policy
+// actually dropped a batch, without having to decode the trace wire
+// format to find the resulting traceEvDropped event.
+var (
+	traceDroppedForTestMu mutex
+	traceDroppedForTest   int
+)
+
+func traceDroppedForTestAdd(n int) {
+	lock(&traceDroppedForTestMu)
+	traceDroppedForTest += n
+	unlock(&traceDroppedForTestMu)
+}
+
+func traceDroppedForTestLoad() int {
+	lock(&traceDroppedForTestMu)
+	n := traceDroppedForTest
+	unlock(&traceDroppedForTestMu)
+	return n
+}
+
+func traceDroppedForTestReset() {
+	lock(&traceDroppedForTestMu)
+	traceDroppedForTest = 0
+	unlock(&traceDroppedForTestMu)
+}
+
+// traceToWriter pumps ReadTrace into w until tracing stops, applying
+// opts.Policy once more than opts.MaxBufferedBytes of trace data has
+// queued up because w can't keep up with the producers. Reading from
+// the runtime and writing to w happen on separate goroutines,
+// connected by a queue guarded by qlock: if w.Write ran inline in the
+// ReadTrace loop, as it previously did, the queue would never hold
+// more than the one batch just read, so it could never actually grow
+// past MaxBufferedBytes and DropOldestBatch could never trigger.
+func traceToWriter(w io.Writer, opts TraceOptions) {
+	var qlock mutex
+	var queue [][]byte
+	var queued int
+	closed := false
+	wake := make(chan struct{}, 1)
+	writerDone := make(chan struct{})
+
+	notify := func() {
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(writerDone)
+		for {
+			lock(&qlock)
+			for len(queue) == 0 && !closed {
+				unlock(&qlock)
+				<-wake
+				lock(&qlock)
+			}
+			if len(queue) == 0 {
+				unlock(&qlock)
+				return
+			}
+			buf := queue[0]
+			queue = queue[1:]
+			queued -= len(buf)
+			unlock(&qlock)
+			if _, err := w.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		buf := ReadTrace()
+		if buf == nil {
+			break
+		}
+		b := append([]byte(nil), buf...)
+
+		lock(&qlock)
+		queue = append(queue, b)
+		queued += len(b)
+		numDropped := 0
+		if opts.Policy == DropOldestBatch {
+			for queued > opts.MaxBufferedBytes && len(queue) > 1 {
+				queued -= len(queue[0])
+				queue = queue[1:]
+				numDropped++
+			}
+		}
+		unlock(&qlock)
+		notify()
+
+		if numDropped > 0 {
+			traceDroppedForTestAdd(numDropped)
+			for i := 0; i < numDropped; i++ {
+				// Emitted through the normal trace-event path, while
+				// tracing is still active, so the drop gets a proper
+				// timestamp and batch-header context instead of being
+				// a malformed tail appended after ReadTrace has
+				// already produced the stream's footer.
+				traceEvent(traceEvDropped, -1, 1)
+			}
+		}
+	}
+
+	lock(&qlock)
+	closed = true
+	unlock(&qlock)
+	notify()
+	<-writerDone
+}
+
+// traceTLVTagClockID identifies the one TLV entry the trace header
+// currently carries: a single byte naming the traceClock in use,
+// written by traceClockID.
+const traceTLVTagClockID = 1
+
+// traceClock is the time source used to stamp trace events.
+// traceTickDiv's hard-coded per-GOARCH divisors assume cputicks is a
+// real, cheap hardware counter; on architectures where cputicks is
+// actually emulated by nanotime, tracing with it is unreliable, so
+// StartTrace calibrates and picks whichever available clock looks
+// best instead of always trusting cputicks.
+type traceClock interface {
+	// ticks returns a monotonically non-decreasing count of some
+	// clock-specific unit.
+	ticks() int64
+}
+
+type traceClockCputicks struct{}
+
+func (traceClockCputicks) ticks() int64 { return cputicks() }
+
+type traceClockNanotime struct{}
+
+func (traceClockNanotime) ticks() int64 { return nanotime() }
+
+// traceClockID maps a traceClock to the byte persisted in the trace
+// header's clock-id TLV entry.
+func traceClockID(c traceClock) byte {
+	switch c.(type) {
+	case traceClockNanotime:
+		return 1
+	case traceClockMonotonicRaw:
+		return 2
+	default:
+		return 0 // traceClockCputicks, the historical default
+	}
+}
+
+// traceProbeClock samples c over a short calibration window and
+// reports the smallest positive tick delta it observed, converted to
+// nanoseconds using nanotime as a common reference clock, together
+// with whether c ever appeared to run backwards. The conversion
+// matters because candidate clocks don't share a unit: a minimum
+// delta of, say, 20 raw cputicks on a multi-GHz part is a far finer
+// resolution than a minimum delta of 20 whole nanoseconds, so the
+// two can't be compared as raw tick counts the way traceChooseClock
+// needs to.
+func traceProbeClock(c traceClock) (resolutionNS int64, monotonic bool) {
+	const samples = 64
+	startTicks := c.ticks()
+	startNS := nanotime()
+	prev := startTicks
+	minDiff := int64(1) << 62
+	for i := 0; i < samples; i++ {
+		cur := c.ticks()
+		diff := cur - prev
+		if diff < 0 {
+			return 0, false
+		}
+		if diff > 0 && diff < minDiff {
+			minDiff = diff
+		}
+		prev = cur
+	}
+	endNS := nanotime()
+	elapsedTicks := prev - startTicks
+	elapsedNS := endNS - startNS
+	if elapsedTicks <= 0 || elapsedNS <= 0 {
+		// Couldn't calibrate a tick rate over this window (e.g. c
+		// ticks slower than nanotime does); treat minDiff as already
+		// being in nanoseconds, the conservative, coarsest-possible
+		// assumption, rather than risk a divide by zero. A clock
+		// that never advanced keeps minDiff at its initial, huge
+		// value, so it never looks finer than another candidate.
+		return minDiff, true
+	}
+	resolutionNS = minDiff * elapsedNS / elapsedTicks
+	if resolutionNS == 0 {
+		resolutionNS = 1
+	}
+	return resolutionNS, true
+}
+
+// traceChooseClock probes the clocks available on this platform and
+// picks the one with the best resolution among those that never ran
+// backwards during calibration, falling back to nanotime (always
+// monotonic, if sometimes coarse) when nothing better is found.
+func traceChooseClock() traceClock {
+	return traceChooseClockFrom(traceClockCandidates())
+}
+
+// traceChooseClockFrom is traceChooseClock's candidate-picking logic,
+// split out so tests can exercise it against fake traceClocks instead
+// of only the platform's real traceClockCandidates().
+func traceChooseClockFrom(candidates []traceClock) traceClock {
+	var best traceClock = traceClockNanotime{}
+	bestRes, _ := traceProbeClock(best)
+	for _, c := range candidates {
+		res, ok := traceProbeClock(c)
+		if ok && res < bestRes {
+			best, bestRes = c, res
+		}
+	}
+	return best
+}
@@ -0,0 +1,84 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package trace lets application code annotate a runtime trace
+// (started with runtime.StartTrace or runtime.StartTraceFiltered)
+// with its own tasks, regions and log messages, so that
+// latency-critical application spans can be correlated with
+// scheduler and GC activity in the same trace stream.
+package trace
+
+import (
+	"context"
+	"runtime"
+)
+
+type contextKey struct{}
+
+// Task represents a user-defined logical operation that may span
+// many goroutines. Tasks nest: a Task created from a Context that
+// already carries one becomes its child, so a trace viewer can group
+// related tasks together.
+type Task struct {
+	id uint64
+}
+
+// NewTask creates a Task, parented to whatever Task pctx carries (if
+// any), and returns a Context carrying the new Task alongside pctx's
+// existing values. Regions and log messages recorded against the
+// returned Context, or any Context derived from it, are attributed to
+// this task.
+func NewTask(pctx context.Context, taskType string) (context.Context, *Task) {
+	var parentID uint64
+	if parent, ok := pctx.Value(contextKey{}).(*Task); ok {
+		parentID = parent.id
+	}
+	t := &Task{id: runtime.NewTraceTask(taskType, parentID)}
+	return context.WithValue(pctx, contextKey{}, t), t
+}
+
+// End marks the task as finished.
+func (t *Task) End() {
+	runtime.EndTraceTask(t.id)
+}
+
+// Region represents a region of code, usually expected to run on a
+// single goroutine, delimited by StartRegion and Region.End.
+type Region struct {
+	end func()
+}
+
+// StartRegion starts a region named regionType within whatever Task
+// ctx carries (or the untasked background region, if none), and
+// returns it so the caller can End it.
+func StartRegion(ctx context.Context, regionType string) *Region {
+	return &Region{end: runtime.TraceRegion(taskID(ctx), "region", regionType)}
+}
+
+// End marks the end of the region.
+func (r *Region) End() {
+	r.end()
+}
+
+// WithRegion starts a region named regionType, runs fn, and ends the
+// region once fn returns. It is the common case where a region's
+// extent matches a single call's lifetime.
+func WithRegion(ctx context.Context, regionType string, fn func()) {
+	r := StartRegion(ctx, regionType)
+	defer r.End()
+	fn()
+}
+
+// Log emits an instant, timestamped log message within whatever Task
+// ctx carries, tagged with category.
+func Log(ctx context.Context, category, message string) {
+	runtime.TraceLog(taskID(ctx), category, message)
+}
+
+func taskID(ctx context.Context) uint64 {
+	if t, ok := ctx.Value(contextKey{}).(*Task); ok {
+		return t.id
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace_test
+
+import (
+	"context"
+	"runtime"
+	"runtime/trace"
+	"testing"
+)
+
+func TestTaskRegionLog(t *testing.T) {
+	if err := runtime.StartTrace(); err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+	defer drainAndStop(t)
+
+	ctx, task := trace.NewTask(context.Background(), "Parent")
+	defer task.End()
+
+	child, childTask := trace.NewTask(ctx, "Child")
+	trace.WithRegion(child, "work", func() {
+		trace.Log(child, "progress", "started")
+	})
+	childTask.End()
+}
+
+// drainAndStop stops tracing after reading out whatever was recorded,
+// since runtime.StopTrace blocks until the trace reader drains and
+// nothing else is reading in this test.
+func drainAndStop(t *testing.T) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		for runtime.ReadTrace() != nil {
+		}
+		close(done)
+	}()
+	runtime.StopTrace()
+	<-done
+}
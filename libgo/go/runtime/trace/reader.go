@@ -0,0 +1,39 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace
+
+import (
+	"io"
+	"runtime"
+)
+
+// reader adapts runtime.ReadTrace's chunked, pull-based interface to
+// io.Reader, so callers can stream a trace to disk or over the
+// network with the usual io plumbing instead of writing their own
+// ReadTrace loop.
+type reader struct {
+	buf []byte
+}
+
+// NewReader returns an io.Reader over the binary trace stream
+// produced while tracing is enabled (see runtime.StartTrace and
+// runtime.StartTraceFiltered). Read blocks until a buffer of trace
+// data is available or tracing has stopped, in which case it returns
+// io.EOF.
+func NewReader() io.Reader {
+	return &reader{}
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		r.buf = runtime.ReadTrace()
+		if r.buf == nil {
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
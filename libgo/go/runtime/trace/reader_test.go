@@ -0,0 +1,53 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package trace_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"runtime"
+	"runtime/trace"
+	"testing"
+)
+
+// TestReaderStreamsTrace checks that streaming a trace through the
+// io.Reader returned by NewReader, while tracing is stopped
+// concurrently, yields a non-empty stream that begins with the trace
+// header. It does not compare the stream byte for byte against a
+// direct runtime.ReadTrace loop.
+func TestReaderStreamsTrace(t *testing.T) {
+	if err := runtime.StartTrace(); err != nil {
+		t.Fatalf("StartTrace: %v", err)
+	}
+
+	ctx, task := trace.NewTask(context.Background(), "TestTask")
+	trace.Log(ctx, "cat", "msg")
+	task.End()
+
+	r := trace.NewReader()
+	done := make(chan []byte, 1)
+	go func() {
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("ReadAll: %v", err)
+		}
+		done <- b
+	}()
+
+	runtime.StopTrace()
+	got := <-done
+
+	if len(got) == 0 {
+		t.Fatal("NewReader produced no trace data")
+	}
+	if !bytes.HasPrefix(got, []byte("go 1.10 trace")) {
+		n := len(got)
+		if n > 32 {
+			n = 32
+		}
+		t.Fatalf("trace data missing expected header, got %q", got[:n])
+	}
+}
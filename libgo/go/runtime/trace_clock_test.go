@@ -0,0 +1,58 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import "testing"
+
+// fakeTraceClock is a traceClock whose ticks sequence is scripted by
+// a test, so traceProbeClock/traceChooseClockFrom can be exercised
+// against clocks with known-bad behavior that the real platform
+// clocks (hopefully) never exhibit.
+type fakeTraceClock struct {
+	seq []int64
+	i   int
+}
+
+func (c *fakeTraceClock) ticks() int64 {
+	v := c.seq[c.i]
+	if c.i < len(c.seq)-1 {
+		c.i++
+	}
+	return v
+}
+
+func TestTraceProbeClockRejectsNonMonotonic(t *testing.T) {
+	// A tick sequence that runs backwards partway through must be
+	// reported as non-monotonic, regardless of how fine its steps are
+	// elsewhere.
+	c := &fakeTraceClock{seq: []int64{0, 10, 20, 15, 30}}
+	if _, monotonic := traceProbeClock(c); monotonic {
+		t.Fatal("traceProbeClock reported a backwards-running clock as monotonic")
+	}
+}
+
+func TestTraceChooseClockFromAvoidsNonMonotonic(t *testing.T) {
+	fine := &fakeTraceClock{seq: []int64{0, 1, 2, 3, 4, 5}}
+	backwards := &fakeTraceClock{seq: []int64{0, 100, 50, 200, 300}}
+
+	got := traceChooseClockFrom([]traceClock{backwards, fine})
+	if got != traceClock(fine) {
+		t.Fatalf("traceChooseClockFrom picked %#v, want the monotonic fine-grained clock", got)
+	}
+}
+
+func TestTraceChooseClockFromPrefersFinerResolution(t *testing.T) {
+	// Both clocks are monotonic, but coarse ticks 100 units at a
+	// time while fine ticks 1 unit at a time; traceChooseClockFrom
+	// should prefer fine regardless of which unit each happens to
+	// count in, since traceProbeClock normalizes to nanoseconds.
+	coarse := &fakeTraceClock{seq: []int64{0, 100, 200, 300, 400}}
+	fine := &fakeTraceClock{seq: []int64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10}}
+
+	got := traceChooseClockFrom([]traceClock{coarse, fine})
+	if got != traceClock(fine) {
+		t.Fatalf("traceChooseClockFrom picked %#v, want the finer-grained clock", got)
+	}
+}
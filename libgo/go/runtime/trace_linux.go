@@ -0,0 +1,29 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build linux
+
+package runtime
+
+// traceClockMonotonicRaw uses CLOCK_MONOTONIC_RAW, which unlike
+// CLOCK_MONOTONIC is never subject to NTP frequency or phase
+// adjustments. That makes it the best candidate traceChooseClock has
+// to offer on Linux, via the vDSO fast path glibc's clock_gettime
+// already uses for it.
+type traceClockMonotonicRaw struct{}
+
+func (traceClockMonotonicRaw) ticks() int64 {
+	var ts _timespec
+	clock_gettime(_CLOCK_MONOTONIC_RAW, &ts)
+	return int64(ts.tv_sec)*1e9 + int64(ts.tv_nsec)
+}
+
+const _CLOCK_MONOTONIC_RAW = 4
+
+//extern clock_gettime
+func clock_gettime(clockid int32, ts *_timespec) int32
+
+func traceClockCandidates() []traceClock {
+	return []traceClock{traceClockCputicks{}, traceClockMonotonicRaw{}}
+}
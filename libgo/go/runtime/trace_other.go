@@ -0,0 +1,18 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// +build !linux
+
+package runtime
+
+// traceClockMonotonicRaw has no implementation outside Linux; it is
+// never returned by traceClockCandidates on these GOOSes, so its
+// methods are unreachable.
+type traceClockMonotonicRaw struct{}
+
+func (traceClockMonotonicRaw) ticks() int64 { return nanotime() }
+
+func traceClockCandidates() []traceClock {
+	return []traceClock{traceClockCputicks{}}
+}
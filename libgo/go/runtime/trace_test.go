@@ -0,0 +1,55 @@
+// Copyright 2016 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+// blockingWriter lets the first allow writes through, then blocks
+// every subsequent Write until release is closed, simulating a
+// consumer that can't keep up with StartTraceToWriter's producers.
+type blockingWriter struct {
+	allow   int
+	release chan struct{}
+	n       int
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.n++
+	if w.n > w.allow {
+		<-w.release
+	}
+	return len(p), nil
+}
+
+// TestTraceToWriterDropsOnSlowConsumer checks that DropOldestBatch
+// actually drops queued batches, rather than silently behaving like
+// BlockProducers, once a slow writer lets the queue grow past
+// MaxBufferedBytes.
+func TestTraceToWriterDropsOnSlowConsumer(t *testing.T) {
+	w := &blockingWriter{allow: 1, release: make(chan struct{})}
+	if err := StartTraceToWriter(w, TraceOptions{MaxBufferedBytes: 1, Policy: DropOldestBatch}); err != nil {
+		t.Fatalf("StartTraceToWriter: %v", err)
+	}
+
+	// Generate scheduling activity, while w.Write is stuck on
+	// w.release, so the queue has new batches to grow by instead of
+	// sitting idle waiting on ReadTrace.
+	deadline := time.Now().Add(2 * time.Second)
+	for traceDroppedForTestLoad() == 0 && time.Now().Before(deadline) {
+		done := make(chan struct{})
+		go func() { close(done) }()
+		<-done
+	}
+
+	close(w.release)
+	StopTrace()
+
+	if n := traceDroppedForTestLoad(); n == 0 {
+		t.Fatal("expected StartTraceToWriter to drop at least one batch under a slow writer")
+	}
+}